@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(App{})
+}
+
+// App is the top-level ratelimit app: it owns the named Zones shared by
+// however many `rate_limit` handlers reference them.
+type App struct {
+	// Zones maps zone name to its configuration.
+	Zones map[string]*Zone `json:"zones,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "ratelimit",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision sets up every configured zone.
+func (a *App) Provision(ctx caddy.Context) error {
+	for name, zone := range a.Zones {
+		if err := zone.provision(ctx); err != nil {
+			return fmt.Errorf("provisioning zone %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that every zone has a usable config.
+func (a *App) Validate() error {
+	for name, zone := range a.Zones {
+		if err := zone.validate(); err != nil {
+			return fmt.Errorf("zone %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Start is a no-op; zones are already live once Provision has run.
+func (a *App) Start() error { return nil }
+
+// Stop is a no-op.
+func (a *App) Stop() error { return nil }
+
+// zone looks up a configured zone by name.
+func (a *App) zone(name string) (*Zone, error) {
+	zone, ok := a.Zones[name]
+	if !ok {
+		return nil, fmt.Errorf("no rate_limit zone named %q", name)
+	}
+	return zone, nil
+}
+
+// Interface guards
+var (
+	_ caddy.App         = (*App)(nil)
+	_ caddy.Provisioner = (*App)(nil)
+	_ caddy.Validator   = (*App)(nil)
+)
@@ -16,59 +16,238 @@ package ratelimit
 
 import (
 	"fmt"
+	"strconv"
+
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
-	"strconv"
 )
 
+func init() {
+	httpcaddyfile.RegisterGlobalOption("ratelimit", parseApp)
+}
+
+// parseApp parses the top-level `ratelimit` global option, which holds one
+// or more named zones:
+//
+//	ratelimit {
+//		zone myzone {
+//			max_requests 100
+//			window_length 1m
+//		}
+//	}
+func parseApp(d *caddyfile.Dispenser, existingVal interface{}) (interface{}, error) {
+	app, ok := existingVal.(*App)
+	if !ok {
+		app = new(App)
+	}
+	if app.Zones == nil {
+		app.Zones = make(map[string]*Zone)
+	}
+
+	for d.Next() {
+		for d.NextBlock(0) {
+			if d.Val() != "zone" {
+				return nil, d.Errf("unrecognized ratelimit option '%s'", d.Val())
+			}
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			name := d.Val()
+
+			zone := new(Zone)
+			if err := zone.UnmarshalCaddyfile(d); err != nil {
+				return nil, err
+			}
+			app.Zones[name] = zone
+		}
+	}
+
+	return httpcaddyfile.App{
+		Name:  "ratelimit",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// parseRateLimit parses the `rate_limit` HTTP directive, which just names
+// the zone (configured via the top-level ratelimit option) that the
+// handler should enforce.
 func parseRateLimit(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var rl RateLimit
 	err := rl.UnmarshalCaddyfile(h.Dispenser)
-	return rl, err
+	return &rl, err
 }
 
+// UnmarshalCaddyfile sets up rl from Caddyfile tokens, e.g.:
+//
+//	rate_limit myzone
 func (rl *RateLimit) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
-		for d.NextBlock(0) {
-			switch d.Val() {
-			case "by_header":
-				if !d.NextArg() {
-					return d.ArgErr()
-				}
-				rl.ByHeader = d.Val()
-			case "max_requests":
-				if !d.NextArg() {
-					return d.ArgErr()
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		rl.Zone = d.Val()
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up z from the body of a `zone` block.
+func (z *Zone) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "vary_by":
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "remote_ip":
+					z.VaryBy.RemoteIP = true
+				case "header":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.VaryBy.Headers = append(z.VaryBy.Headers, d.Val())
+				case "cookie":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.VaryBy.Cookies = append(z.VaryBy.Cookies, d.Val())
+				case "method":
+					z.VaryBy.Method = true
+				case "path_prefix":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					if z.VaryBy.Path == nil {
+						z.VaryBy.Path = &VaryByPath{}
+					}
+					z.VaryBy.Path.Prefix = d.Val()
+				case "path_regexp":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					if z.VaryBy.Path == nil {
+						z.VaryBy.Path = &VaryByPath{}
+					}
+					z.VaryBy.Path.Regexp = d.Val()
+				case "placeholder":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.VaryBy.Placeholders = append(z.VaryBy.Placeholders, d.Val())
+				default:
+					return d.Errf("unrecognized vary_by option '%s'", d.Val())
 				}
-				if num, err := strconv.Atoi(d.Val()); err != nil {
-					return fmt.Errorf("max requests %v could not be parsed as a number", d.Val())
-				} else {
-					rl.MaxRequests = int64(num)
+			}
+		case "max_requests":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if num, err := strconv.Atoi(d.Val()); err != nil {
+				return fmt.Errorf("max requests %v could not be parsed as a number", d.Val())
+			} else {
+				z.MaxRequests = int64(num)
+			}
+		case "window_length":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			duration, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return fmt.Errorf("window_length %v could not be parsed as a duration", d.Val())
+			} else {
+				z.WindowLength = caddy.Duration(duration)
+			}
+		case "algorithm":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			z.Algorithm = d.Val()
+		case "store":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			z.Store.Type = d.Val()
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "addr":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.Store.Addr = d.Val()
+				case "password":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.Store.Password = d.Val()
+				case "db":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					db, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return fmt.Errorf("db %v could not be parsed as a number", d.Val())
+					}
+					z.Store.DB = db
+				case "prefix":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.Store.Prefix = d.Val()
+				default:
+					return d.Errf("unrecognized store option '%s'", d.Val())
 				}
-			case "window_length":
-				if !d.NextArg() {
-					return d.ArgErr()
+			}
+		case "response":
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "body":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.Response.Body = d.Val()
+				default:
+					return d.Errf("unrecognized response option '%s'; a custom handler response requires the JSON config", d.Val())
 				}
-				duration, err := caddy.ParseDuration(d.Val())
-				if err != nil {
-					return fmt.Errorf("window_length %v could not be parsed as a duration", d.Val())
-				} else {
-					rl.WindowLength = caddy.Duration(duration)
+			}
+		case "client_ip":
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "trusted_proxies":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					z.ClientIP.TrustedProxies = append(z.ClientIP.TrustedProxies, args...)
+				case "header":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					z.ClientIP.Headers = append(z.ClientIP.Headers, d.Val())
+				default:
+					return d.Errf("unrecognized client_ip option '%s'", d.Val())
 				}
-			default:
-				return d.Errf("unrecognized servers option '%s'", d.Val())
 			}
+		case "allow":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			z.Allow = append(z.Allow, args...)
+		case "deny":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			z.Deny = append(z.Deny, args...)
+		case "dry_run":
+			z.DryRun = true
+		default:
+			return d.Errf("unrecognized zone option '%s'", d.Val())
 		}
 	}
 	return nil
 }
-
-// Validate validates that the module has a usable config.
-func (rl RateLimit) Validate() error {
-	if rl.MaxRequests <= 0 || rl.WindowLength <= 0 {
-		return fmt.Errorf("max_requests and window_length must be positive")
-	}
-	return nil
-}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// defaultClientIPHeaders are the headers checked, in order, for a client IP
+// reported by a trusted proxy.
+var defaultClientIPHeaders = []string{"X-Forwarded-For", "X-Real-IP", "CF-Connecting-IP"}
+
+// ClientIPConfig configures how a request's client IP is determined. With
+// no TrustedProxies configured, it's always just the connection's remote
+// address; once TrustedProxies is set, a request arriving from one of them
+// is treated as having passed through a proxy, and the client IP is instead
+// read from Headers (or the PROXY protocol, if Caddy terminated one).
+type ClientIPConfig struct {
+	// TrustedProxies lists the IPs and CIDR ranges of proxies allowed to
+	// report a client IP on the connection's behalf, e.g. a load balancer
+	// or CDN in front of Caddy.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// Headers lists, in order of preference, the headers checked for a
+	// client IP once the immediate peer is a trusted proxy. Defaults to
+	// X-Forwarded-For, X-Real-IP and CF-Connecting-IP.
+	Headers []string `json:"headers,omitempty"`
+
+	trustedNets []*net.IPNet
+}
+
+// provision parses c's trusted proxies and fills in its default headers.
+func (c *ClientIPConfig) provision() error {
+	trustedNets, err := parseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted_proxies: %v", err)
+	}
+	c.trustedNets = trustedNets
+
+	if len(c.Headers) == 0 {
+		c.Headers = defaultClientIPHeaders
+	}
+
+	return nil
+}
+
+// clientIP returns r's client IP, used as the default rate-limit key
+// component when VaryBy doesn't select anything else, and to match r
+// against a zone's allow/deny lists.
+func (c *ClientIPConfig) clientIP(r *http.Request) string {
+	remoteIP := hostOnly(r.RemoteAddr)
+
+	if !matchesAny(net.ParseIP(remoteIP), c.trustedNets) {
+		return remoteIP
+	}
+
+	if proxyAddr, ok := caddyhttp.GetVar(r.Context(), "proxy_protocol_address").(string); ok && proxyAddr != "" {
+		return hostOnly(proxyAddr)
+	}
+
+	for _, header := range c.Headers {
+		value := r.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		if !strings.EqualFold(header, "X-Forwarded-For") {
+			return strings.TrimSpace(value)
+		}
+
+		// X-Forwarded-For is a comma-separated chain with the original
+		// client first and each successive proxy appended to the right,
+		// so the real client is the rightmost entry that isn't itself one
+		// of our trusted proxies.
+		hops := strings.Split(value, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if !matchesAny(net.ParseIP(hop), c.trustedNets) {
+				return hop
+			}
+		}
+	}
+
+	return remoteIP
+}
+
+// hostOnly strips the port from a host:port address (including bracketed
+// IPv6 addresses), falling back to addr as-is if it isn't in that form.
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ClientIPConfig_clientIP(t *testing.T) {
+	t.Run("defaults to the connection's remote address", func(t *testing.T) {
+		c := ClientIPConfig{}
+		if err := c.provision(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.127:54321"
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+		if ip := c.clientIP(r); ip != "10.0.0.127" {
+			t.Errorf("expected the untrusted remote address to win, got %q", ip)
+		}
+	})
+
+	t.Run("ignores forwarded headers from an untrusted peer", func(t *testing.T) {
+		c := ClientIPConfig{TrustedProxies: []string{"192.168.0.0/16"}}
+		if err := c.provision(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.127:54321" // not in 192.168.0.0/16
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+		if ip := c.clientIP(r); ip != "10.0.0.127" {
+			t.Errorf("expected the remote address, got %q", ip)
+		}
+	})
+
+	t.Run("walks X-Forwarded-For right-to-left past trusted proxies", func(t *testing.T) {
+		c := ClientIPConfig{TrustedProxies: []string{"192.168.0.0/16"}}
+		if err := c.provision(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.1.1:443"
+		// the rightmost hop (192.168.1.50) is itself a trusted proxy, so it
+		// must be skipped in favor of the untrusted hop to its left.
+		r.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.1.50")
+
+		if ip := c.clientIP(r); ip != "1.2.3.4" {
+			t.Errorf("expected to skip the trusted proxy hop, got %q", ip)
+		}
+	})
+
+	t.Run("falls through to X-Real-IP when X-Forwarded-For is all trusted", func(t *testing.T) {
+		c := ClientIPConfig{TrustedProxies: []string{"192.168.0.0/16"}}
+		if err := c.provision(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.1.1:443"
+		r.Header.Set("X-Forwarded-For", "192.168.1.50")
+		r.Header.Set("X-Real-IP", "1.2.3.4")
+
+		if ip := c.clientIP(r); ip != "1.2.3.4" {
+			t.Errorf("expected X-Real-IP fallback, got %q", ip)
+		}
+	})
+
+	t.Run("handles bracketed IPv6 remote addresses", func(t *testing.T) {
+		c := ClientIPConfig{}
+		if err := c.provision(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "[2001:db8::1]:54321"
+
+		if ip := c.clientIP(r); ip != "2001:db8::1" {
+			t.Errorf("expected the IPv6 address without its port, got %q", ip)
+		}
+	})
+}
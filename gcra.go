@@ -0,0 +1,67 @@
+package ratelimit
+
+import "time"
+
+// gcraLimiter implements the GCRA (generic cell rate algorithm), a form of
+// leaky bucket rate limiting that paces requests smoothly instead of
+// counting them into discrete windows. Unlike the windowed
+// RequestCountTracker, it needs only a single piece of state per key: the
+// theoretical arrival time (TAT) of the next request that would keep the
+// bucket exactly at its configured rate.
+type gcraLimiter struct {
+	store Store
+
+	// emissionInterval is the minimum spacing between requests: window /
+	// maxRequests.
+	emissionInterval time.Duration
+
+	// burstTolerance is how far the TAT may run ahead of now before a
+	// request is rejected; equal to the configured window, so the limiter
+	// allows the same maxRequests-per-window burst as the sliding window
+	// algorithm, just paced rather than bucketed.
+	burstTolerance time.Duration
+}
+
+// newGCRALimiter returns a gcraLimiter that allows up to maxRequests per
+// window, backed by store.
+func newGCRALimiter(store Store, window time.Duration, maxRequests int64) *gcraLimiter {
+	return &gcraLimiter{
+		store:            store,
+		emissionInterval: window / time.Duration(maxRequests),
+		burstTolerance:   window,
+	}
+}
+
+// gcraResult reports the outcome of a gcraLimiter.allow call, including
+// enough detail to populate the RateLimit-* response headers.
+type gcraResult struct {
+	allowed    bool
+	remaining  int64
+	resetAt    time.Time
+	retryAfter time.Duration
+}
+
+// allow reports whether a request for key should be allowed under the
+// limiter's rate.
+func (g *gcraLimiter) allow(key string) gcraResult {
+	now := time.Now()
+
+	allowed, tat, retryAfter, err := g.store.Allow(key, now, g.emissionInterval, g.burstTolerance)
+	if err != nil {
+		// fail open, consistent with RequestCountTracker's handling of
+		// store errors
+		return gcraResult{allowed: true}
+	}
+
+	remaining := int64((g.burstTolerance - tat.Sub(now)) / g.emissionInterval)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return gcraResult{
+		allowed:    allowed,
+		remaining:  remaining,
+		resetAt:    now.Add(g.burstTolerance),
+		retryAfter: retryAfter,
+	}
+}
@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_gcraLimiter_allow(t *testing.T) {
+	// 5 requests per second, one request per 200ms on average
+	limiter := newGCRALimiter(newMemoryStore(), time.Second, 5)
+
+	for i := 0; i < 5; i++ {
+		if result := limiter.allow("10.0.0.127"); !result.allowed {
+			t.Fatalf("request %d should have been within burst tolerance", i)
+		}
+	}
+
+	result := limiter.allow("10.0.0.127")
+	if result.allowed {
+		t.Errorf("6th immediate request should have been denied")
+	}
+	if result.retryAfter <= 0 {
+		t.Errorf("expected a positive retryAfter, got %v", result.retryAfter)
+	}
+
+	if result := limiter.allow("10.0.0.128"); !result.allowed {
+		t.Errorf("a different key should have its own independent limit")
+	}
+}
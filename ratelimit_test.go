@@ -1,7 +1,6 @@
 package ratelimit
 
 import (
-	"sync"
 	"testing"
 	"time"
 
@@ -10,34 +9,36 @@ import (
 
 func Test_rateLimitOptions_refreshWindows(t *testing.T) {
 	t.Run("Should refresh", func(t *testing.T) {
-		RateLimit := RateLimit{
+		store := newMemoryStore()
+		zone := Zone{
 			WindowLength: 15 * 60,
+			store:        store,
 			currentWindow: &RequestCountTracker{
-				requestCount: map[string]int64{},
-				startTime:    time.Now().Add(-35 * time.Minute),
-				endTime:      time.Now().Add(-20 * time.Minute),
-				_mutex:       &sync.RWMutex{},
+				store:     store,
+				startTime: time.Now().Add(-35 * time.Minute),
+				endTime:   time.Now().Add(-20 * time.Minute),
 			},
 		}
 
-		if didRefresh := RateLimit.refreshWindows(); !didRefresh {
-			t.Errorf("Should have refreshed, but did not: %+v", RateLimit)
+		if didRefresh := zone.refreshWindows(); !didRefresh {
+			t.Errorf("Should have refreshed, but did not: %+v", zone)
 		}
 	})
 
 	t.Run("Should not refresh", func(t *testing.T) {
-		RateLimit := RateLimit{
+		store := newMemoryStore()
+		zone := Zone{
 			WindowLength: 60,
+			store:        store,
 			currentWindow: &RequestCountTracker{
-				requestCount: map[string]int64{},
-				startTime:    time.Now().Add(-30 * time.Minute),
-				endTime:      time.Now().Add(30 * time.Minute),
-				_mutex:       &sync.RWMutex{},
+				store:     store,
+				startTime: time.Now().Add(-30 * time.Minute),
+				endTime:   time.Now().Add(30 * time.Minute),
 			},
 		}
 
-		if didRefresh := RateLimit.refreshWindows(); didRefresh {
-			t.Errorf("Should not have refreshed, but did: %+v", RateLimit)
+		if didRefresh := zone.refreshWindows(); didRefresh {
+			t.Errorf("Should not have refreshed, but did: %+v", zone)
 		}
 	})
 }
@@ -48,133 +49,89 @@ func Test_rateLimitOptions_blockingAndRequestCounting(t *testing.T) {
 	// getInterpolatedRequestCount()
 	hostName := "10.0.0.127"
 
-	rl := RateLimit{
-		ByHeader:     "",
+	zone := Zone{
 		WindowLength: caddy.Duration(20 * time.Minute),
 		MaxRequests:  200,
 	}
-	rl.Provision(caddy.Context{})
+	zone.provision(caddy.Context{})
 
-	rl.currentWindow.requestCount[hostName] = 100
-	rl.currentWindow.startTime = rl.currentWindow.startTime.Add(-10 * time.Minute)
-	rl.currentWindow.endTime = rl.currentWindow.endTime.Add(-10 * time.Minute)
+	zone.currentWindow.startTime = zone.currentWindow.startTime.Add(-10 * time.Minute)
+	zone.currentWindow.endTime = zone.currentWindow.endTime.Add(-10 * time.Minute)
+	zone.store.(*memoryStore).counts[zone.currentWindow.keyPrefix+hostName] = 100
+	zone.store.(*memoryStore).expiry[zone.currentWindow.keyPrefix+hostName] = zone.currentWindow.endTime
 
 	// start/end time doesn't really matter for previous window
-	rl.previousWindow = &RequestCountTracker{
-		requestCount: map[string]int64{hostName: 50},
-		_mutex:       &sync.RWMutex{},
+	zone.previousWindow = &RequestCountTracker{
+		store:     zone.store,
+		keyPrefix: "previous:",
 	}
+	zone.store.(*memoryStore).counts[zone.previousWindow.keyPrefix+hostName] = 50
+	zone.store.(*memoryStore).expiry[zone.previousWindow.keyPrefix+hostName] = time.Now().Add(time.Hour)
 
 	t.Run("50-50 split should interpolate to 75 requests", func(t *testing.T) {
 		// expected result is (100+50) / 2
-		if requestCount := rl.getInterpolatedRequestCount(hostName); requestCount != 75 {
+		if requestCount := zone.getInterpolatedRequestCount(hostName); requestCount != 75 {
 			t.Errorf("Expected requestCount of 75 for 50-50 split, got %v", requestCount)
 		}
 	})
 
 	t.Run("50-50 split should not block as 76 < 100", func(t *testing.T) {
-		if shouldBlock := rl.requestShouldBlock(hostName); shouldBlock {
-			t.Errorf("Well clear of max request count, should not block, got %+v", rl)
+		if shouldBlock := zone.requestShouldBlock(hostName); shouldBlock {
+			t.Errorf("Well clear of max request count, should not block, got %+v", zone)
 		}
 
 	})
 
 	// test whether blocking works
-	rl.MaxRequests = 50
+	zone.MaxRequests = 50
 	t.Run("50-50 split should block with now reduced maxRequest as 77 > 50", func(t *testing.T) {
-		if shouldBlock := rl.requestShouldBlock(hostName); !shouldBlock {
-			t.Errorf("Should have blocked with reduced maxRequests, did not, got %+v", rl)
-		}
-	})
-}
-
-func Test_rateLimitOptions_blockingAndRequestCountingByHeader(t *testing.T) {
-
-	header := "Authorization"
-
-	rl := RateLimit{
-		ByHeader:     "",
-		WindowLength: caddy.Duration(20 * time.Minute),
-		MaxRequests:  200,
-	}
-	rl.Provision(caddy.Context{})
-
-	rl.currentWindow.requestCount[header] = 100
-	rl.currentWindow.startTime = rl.currentWindow.startTime.Add(-10 * time.Minute)
-	rl.currentWindow.endTime = rl.currentWindow.endTime.Add(-10 * time.Minute)
-
-	// start/end time doesn't really matter for previous window
-	rl.previousWindow = &RequestCountTracker{
-		requestCount: map[string]int64{header: 50},
-		_mutex:       &sync.RWMutex{},
-	}
-
-	t.Run("50-50 split should interpolate to 75 requests", func(t *testing.T) {
-		// expected result is (100+50) / 2
-		if requestCount := rl.getInterpolatedRequestCount(header); requestCount != 75 {
-			t.Errorf("Expected requestCount of 75 for 50-50 split, got %v", requestCount)
-		}
-	})
-
-	t.Run("50-50 split should not block as 76 < 100", func(t *testing.T) {
-		if shouldBlock := rl.requestShouldBlock(header); shouldBlock {
-			t.Errorf("Well clear of max request count, should not block, got %+v", rl)
-		}
-
-	})
-
-	// test whether blocking works
-	rl.MaxRequests = 50
-	t.Run("50-50 split should block with now reduced maxRequest as 77 > 50", func(t *testing.T) {
-		if shouldBlock := rl.requestShouldBlock(header); !shouldBlock {
-			t.Errorf("Should have blocked with reduced maxRequests, did not, got %+v", rl)
+		if shouldBlock := zone.requestShouldBlock(hostName); !shouldBlock {
+			t.Errorf("Should have blocked with reduced maxRequests, did not, got %+v", zone)
 		}
 	})
 }
 
 func Test_rateLimitOptions_setupRateLimit(t *testing.T) {
 	t.Run("Should initialise properly", func(t *testing.T) {
-		rl := RateLimit{
-			ByHeader:     "",
+		zone := Zone{
 			WindowLength: caddy.Duration(60 * time.Minute),
 			MaxRequests:  200,
 		}
-		rl.Provision(caddy.Context{})
+		zone.provision(caddy.Context{})
 
 		hostName := "localhost"
-		if count := rl.currentWindow.getRequestCountFor(hostName); count != 0 {
+		if count := zone.currentWindow.getRequestCountFor(hostName); count != 0 {
 			t.Errorf("Unexpected request count - expected 0, got %v", count)
 		}
 
-		if count := rl.previousWindow.getRequestCountFor(hostName); count != 0 {
+		if count := zone.previousWindow.getRequestCountFor(hostName); count != 0 {
 			t.Errorf("Unexpected request count - expected 0, got %v", count)
 		}
 	})
 
 	// value of window kept very low for testing, minimum value should be 5 minutes
 	t.Run("Should shuffle windows after one second", func(t *testing.T) {
-		rl := RateLimit{
-			ByHeader:     "",
+		zone := Zone{
 			WindowLength: caddy.Duration(1 * time.Second),
 			MaxRequests:  1000,
 		}
-		rl.Provision(caddy.Context{})
+		zone.provision(caddy.Context{})
 
 		hostName := "localhost"
 
-		rl.requestShouldBlock(hostName)
+		zone.requestShouldBlock(hostName)
 
-		if count := rl.currentWindow.getRequestCountFor(hostName); count != 1 {
+		if count := zone.currentWindow.getRequestCountFor(hostName); count != 1 {
 			t.Errorf("Unexpected request count - expected 1, got %v", count)
 		}
 
 		time.Sleep(1100 * time.Millisecond)
 
-		if count := rl.currentWindow.getRequestCountFor(hostName); count != 0 {
+		if count := zone.currentWindow.getRequestCountFor(hostName); count != 0 {
 			t.Errorf("Unexpected request count - expected 0, got %v", count)
 		}
 
-		if count := rl.previousWindow.getRequestCountFor(hostName); count != 1 {
+		if count := zone.previousWindow.getRequestCountFor(hostName); count != 1 {
 			t.Errorf("Unexpected request count - expected 1, got %v", count)
 		}
 	})
@@ -1,49 +1,81 @@
 package ratelimit
 
 import (
-	"sync"
+	"fmt"
 	"time"
 )
 
-// RequestCountTracker mixes by header and by host on the same structure
+// RequestCountTracker tracks per-key request counts for a single rate
+// limiting window. The counts themselves are delegated to a Store (see
+// store.go), so that the current and previous window are just two views
+// over whatever counter storage the handler is configured to use; this is
+// what lets a Store like Redis be shared by multiple Caddy instances.
+//
+// Keys are namespaced by the window's own end time, so that a new window
+// never sees stale counts left behind by a previous one sharing the same
+// identity.
 type RequestCountTracker struct {
-	requestCount map[string]int64 // If 9,223,372,036,854,775,807 requests isn't enough...
+	store        Store
+	keyPrefix    string
 	startTime    time.Time
 	endTime      time.Time
-	_mutex       *sync.RWMutex
+	windowLength time.Duration
 }
 
 // newRequestCountTracker returns a pointer to a blank initialised RequestCountTracker
-func newRequestCountTracker(windowLength time.Duration) *RequestCountTracker {
+func newRequestCountTracker(windowLength time.Duration, store Store) *RequestCountTracker {
+	now := time.Now()
+	endTime := now.Add(windowLength)
+
 	return &RequestCountTracker{
-		requestCount: map[string]int64{},
-		startTime:    time.Now(),
-		endTime:      time.Now().Add(windowLength),
-		_mutex:       &sync.RWMutex{},
+		store:        store,
+		keyPrefix:    windowKeyPrefix(endTime),
+		startTime:    now,
+		endTime:      endTime,
+		windowLength: windowLength,
 	}
 }
 
 // newPreviousRequestCountTracker returns a pointer to a blank initialised RequestCountTracker for the
 // previous windowLength, it's necessary for initial configuration
-func newPreviousRequestCountTracker(windowLength time.Duration) *RequestCountTracker {
+func newPreviousRequestCountTracker(windowLength time.Duration, store Store) *RequestCountTracker {
+	now := time.Now()
+
 	return &RequestCountTracker{
-		requestCount: map[string]int64{},
-		startTime:    time.Now().Add(-windowLength),
-		endTime:      time.Now(),
-		_mutex:       &sync.RWMutex{},
+		store:        store,
+		keyPrefix:    windowKeyPrefix(now),
+		startTime:    now.Add(-windowLength),
+		endTime:      now,
+		windowLength: windowLength,
 	}
 }
 
+// windowKeyPrefix namespaces a window's keys in the Store by its end time,
+// so that rotating into a new window never reads counts left behind by an
+// older window for the same identity.
+func windowKeyPrefix(endTime time.Time) string {
+	return fmt.Sprintf("%d:", endTime.UnixNano())
+}
+
 // addRequestFor adds to the request counter for specified key
-func (rct *RequestCountTracker) addRequestFor(key string) {
-	rct._mutex.Lock()
-	rct.requestCount[key]++
-	rct._mutex.Unlock()
+func (rct *RequestCountTracker) addRequestFor(key string) int64 {
+	// The counter must outlive this window's own end time: once rotated
+	// into previousWindow, getInterpolatedRequestCount keeps reading it
+	// right up until the *next* rotation, so a ttl that expired exactly at
+	// endTime would already be gone by then.
+	ttl := time.Until(rct.endTime) + rct.windowLength
+	count, err := rct.store.Incr(rct.keyPrefix+key, ttl)
+	if err != nil {
+		return 0
+	}
+	return count
 }
 
-// getRequestCounterForHost gets the request count for a given key
-func (rct *RequestCountTracker) getRequestCountFor(key string) (requestCount int64) {
-	rct._mutex.RLock()
-	defer rct._mutex.RUnlock()
-	return rct.requestCount[key]
+// getRequestCountFor gets the request count for a given key
+func (rct *RequestCountTracker) getRequestCountFor(key string) int64 {
+	count, err := rct.store.Get(rct.keyPrefix + key)
+	if err != nil {
+		return 0
+	}
+	return count
 }
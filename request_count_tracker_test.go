@@ -7,12 +7,18 @@ import (
 
 func Test_RequestCountTracker_getRequestCountFor(t *testing.T) {
 	hostName := "192.168.0.1"
+	endTime := time.Now().Add(time.Hour)
+	keyPrefix := windowKeyPrefix(endTime)
+
+	store := newMemoryStore()
+	store.counts[keyPrefix+hostName] = 200
+	store.expiry[keyPrefix+hostName] = endTime
+
 	rct := RequestCountTracker{
-		requestCount: map[string]int64{
-			hostName: 200,
-		},
+		store:     store,
+		keyPrefix: keyPrefix,
 		startTime: time.Time{},
-		endTime:   time.Time{},
+		endTime:   endTime,
 	}
 
 	t.Run("Should append to existing host's counter", func(t *testing.T) {
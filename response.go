@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// RateLimitResponse configures what's written when a request is denied.
+// With neither Body nor HandlerRaw set, the response is just the bare 429
+// status.
+type RateLimitResponse struct {
+	// Body is written as-is as the response body. Supports Caddy
+	// placeholders.
+	Body string `json:"body,omitempty"`
+
+	// HandlerRaw is a Caddy HTTP handler module invoked to produce the
+	// response instead of writing Body, for a fully custom error page.
+	HandlerRaw json.RawMessage `json:"handler,omitempty" caddy:"namespace=http.handlers inline_key=handler"`
+
+	handler caddyhttp.MiddlewareHandler
+}
+
+// provision loads Response's handler module, if configured.
+func (resp *RateLimitResponse) provision(ctx caddy.Context) error {
+	if resp.HandlerRaw == nil {
+		return nil
+	}
+
+	mod, err := ctx.LoadModule(resp, "HandlerRaw")
+	if err != nil {
+		return fmt.Errorf("loading response handler module: %v", err)
+	}
+
+	handler, ok := mod.(caddyhttp.MiddlewareHandler)
+	if !ok {
+		return fmt.Errorf("response handler module is not a caddyhttp.MiddlewareHandler")
+	}
+	resp.handler = handler
+
+	return nil
+}
+
+// emptyHandler is passed as the "next" handler to Response's handler
+// module, since the rate limit response is meant to be terminal.
+var emptyHandler = caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+	return nil
+})
+
+// writeBlockedResponse writes the 429 response for a denied request,
+// delegating to Response's handler module if one is configured.
+func (z *Zone) writeBlockedResponse(w http.ResponseWriter, r *http.Request) error {
+	if z.Response.handler != nil {
+		return z.Response.handler.ServeHTTP(w, r, emptyHandler)
+	}
+
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	if z.Response.Body == "" {
+		return nil
+	}
+
+	body := z.Response.Body
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		body = repl.ReplaceAll(body, "")
+	}
+
+	_, err := w.Write([]byte(body))
+	return err
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Store is the backing counter for rate-limited keys. Implementations must
+// be safe for concurrent use and are responsible for expiring a counter
+// once the ttl given by the most recent Incr for that key has elapsed.
+//
+// RequestCountTracker namespaces keys by window so that a Store only ever needs to
+// support a flat key space; it never needs to know about windows, hosts,
+// or headers.
+type Store interface {
+	// Incr increments the counter for key by 1 and returns its new value.
+	// If key doesn't exist yet, it's created with the given ttl; otherwise
+	// the ttl is refreshed to reflect the time remaining in the window.
+	Incr(key string, ttl time.Duration) (int64, error)
+
+	// Get returns the current value of the counter for key, or 0 if it
+	// doesn't exist or has expired.
+	Get(key string) (int64, error)
+
+	// Allow applies the GCRA rate-limiting recurrence to key: it computes
+	// newTAT = max(storedTAT, now) + emissionInterval, and allows the
+	// request only if newTAT-now <= burstTolerance. The stored TAT is
+	// advanced to newTAT if and only if the request is allowed, and the
+	// read-compare-write happens as a single atomic operation so that
+	// concurrent requests for the same key can't race past each other.
+	// tat is always the newTAT the recurrence computed, whether or not it
+	// was actually persisted, so callers can derive RateLimit-Remaining.
+	// When the request is denied, retryAfter is how long the caller
+	// should wait before the request would be allowed.
+	Allow(key string, now time.Time, emissionInterval, burstTolerance time.Duration) (allowed bool, tat time.Time, retryAfter time.Duration, err error)
+}
+
+// StoreConfig selects and configures the Store implementation used to hold
+// rate-limit counters.
+type StoreConfig struct {
+	// Type selects the backing implementation: "memory" (the default, one
+	// counter set per Caddy instance) or "redis" (shared across instances).
+	Type string `json:"type,omitempty"`
+
+	// Addr is the Redis server address, e.g. "localhost:6379". Only used
+	// when Type is "redis".
+	Addr string `json:"addr,omitempty"`
+
+	// Password is the Redis AUTH password. Only used when Type is "redis".
+	Password string `json:"password,omitempty"`
+
+	// DB is the Redis logical database number. Only used when Type is
+	// "redis".
+	DB int `json:"db,omitempty"`
+
+	// Prefix is prepended to every key this handler writes to the store,
+	// to avoid collisions with unrelated keys sharing the same Redis
+	// instance. Only used when Type is "redis".
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// build constructs the Store described by sc.
+func (sc StoreConfig) build() (Store, error) {
+	switch sc.Type {
+	case "", "memory":
+		return newMemoryStore(), nil
+	case "redis":
+		return newRedisStore(sc), nil
+	default:
+		return nil, fmt.Errorf("unrecognized store type %q", sc.Type)
+	}
+}
+
+// memoryStore is the default Store: an in-process map of counters, scoped
+// to a single Caddy instance. It's equivalent to the map RequestCountTracker
+// used to hold directly before the Store abstraction was introduced.
+//
+// Outside of sweep, an expired entry is only reset by the next Incr for
+// that exact key (Get just reports it as 0) - but RequestCountTracker namespaces every key by window
+// end time (see windowKeyPrefix), so a rotated-out window's keys are never
+// touched again. sweep, run periodically in the background, is what keeps
+// that from growing the maps without bound.
+type memoryStore struct {
+	mu     sync.RWMutex
+	counts map[string]int64
+	expiry map[string]time.Time
+	tats   map[string]time.Time
+}
+
+// sweepInterval is how often a memoryStore scans for and evicts expired
+// counters and TATs.
+const sweepInterval = time.Minute
+
+func newMemoryStore() *memoryStore {
+	m := &memoryStore{
+		counts: map[string]int64{},
+		expiry: map[string]time.Time{},
+		tats:   map[string]time.Time{},
+	}
+	go m.sweepPeriodically()
+	return m
+}
+
+// sweepPeriodically evicts expired counters and TATs on a timer, for the
+// lifetime of the process. Without this, every rate-limit window rotation
+// (or every distinct vary_by key, under GCRA) would leave behind an entry
+// that's never visited, let alone deleted, again.
+func (m *memoryStore) sweepPeriodically() {
+	for {
+		time.Sleep(sweepInterval)
+		m.sweep()
+	}
+}
+
+// sweep deletes every counter and TAT that's already expired.
+func (m *memoryStore) sweep() {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, exp := range m.expiry {
+		if now.After(exp) {
+			delete(m.counts, key)
+			delete(m.expiry, key)
+		}
+	}
+	for key, tat := range m.tats {
+		if now.After(tat) {
+			delete(m.tats, key)
+		}
+	}
+}
+
+func (m *memoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if exp, ok := m.expiry[key]; ok && time.Now().After(exp) {
+		delete(m.counts, key)
+	}
+
+	m.counts[key]++
+	m.expiry[key] = time.Now().Add(ttl)
+
+	return m.counts[key], nil
+}
+
+func (m *memoryStore) Get(key string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if exp, ok := m.expiry[key]; ok && time.Now().After(exp) {
+		return 0, nil
+	}
+
+	return m.counts[key], nil
+}
+
+func (m *memoryStore) Allow(key string, now time.Time, emissionInterval, burstTolerance time.Duration) (bool, time.Time, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tat := now
+	if stored, ok := m.tats[key]; ok && stored.After(now) {
+		tat = stored
+	}
+
+	newTAT := tat.Add(emissionInterval)
+	if overshoot := newTAT.Sub(now) - burstTolerance; overshoot > 0 {
+		return false, newTAT, overshoot, nil
+	}
+
+	m.tats[key] = newTAT
+	return true, newTAT, 0, nil
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_StoreConfig_build(t *testing.T) {
+	t.Run("defaults to memory store", func(t *testing.T) {
+		store, err := StoreConfig{}.build()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := store.(*memoryStore); !ok {
+			t.Errorf("expected *memoryStore, got %T", store)
+		}
+	})
+
+	t.Run("redis type builds a redisStore", func(t *testing.T) {
+		store, err := StoreConfig{Type: "redis", Addr: "localhost:6379"}.build()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := store.(*redisStore); !ok {
+			t.Errorf("expected *redisStore, got %T", store)
+		}
+	})
+
+	t.Run("unrecognized type errors", func(t *testing.T) {
+		if _, err := (StoreConfig{Type: "bogus"}).build(); err == nil {
+			t.Errorf("expected an error for unrecognized store type, got nil")
+		}
+	})
+}
+
+func Test_memoryStore_IncrAndGet(t *testing.T) {
+	store := newMemoryStore()
+
+	if count, _ := store.Incr("a", time.Minute); count != 1 {
+		t.Errorf("expected 1, got %v", count)
+	}
+	if count, _ := store.Incr("a", time.Minute); count != 2 {
+		t.Errorf("expected 2, got %v", count)
+	}
+	if count, _ := store.Get("a"); count != 2 {
+		t.Errorf("expected 2, got %v", count)
+	}
+	if count, _ := store.Get("unknown"); count != 0 {
+		t.Errorf("expected 0 for unknown key, got %v", count)
+	}
+}
+
+func Test_memoryStore_Allow(t *testing.T) {
+	store := newMemoryStore()
+	now := time.Now()
+
+	emissionInterval := time.Second
+	burstTolerance := 5 * time.Second // allows a burst of up to 5 requests
+
+	for i := 0; i < 5; i++ {
+		if allowed, _, _, _ := store.Allow("a", now, emissionInterval, burstTolerance); !allowed {
+			t.Fatalf("request %d should have been allowed within burst tolerance", i)
+		}
+	}
+
+	allowed, _, retryAfter, _ := store.Allow("a", now, emissionInterval, burstTolerance)
+	if allowed {
+		t.Errorf("6th immediate request should have been denied")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("expected a positive retryAfter, got %v", retryAfter)
+	}
+
+	if allowed, _, _, _ := store.Allow("a", now.Add(time.Second), emissionInterval, burstTolerance); !allowed {
+		t.Errorf("request one emission interval later should have been allowed")
+	}
+}
+
+func Test_memoryStore_expiry(t *testing.T) {
+	store := newMemoryStore()
+
+	store.Incr("a", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if count, _ := store.Get("a"); count != 0 {
+		t.Errorf("expected counter to have expired to 0, got %v", count)
+	}
+
+	if count, _ := store.Incr("a", time.Minute); count != 1 {
+		t.Errorf("expected expired counter to restart from 1, got %v", count)
+	}
+}
@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// KeyFunc extracts one component of a rate-limit key from a request. It's
+// the Go-level extension point VaryBy's Caddyfile/JSON config surface is
+// built on top of; code embedding this module can call AddKeyFunc for
+// keying logic the config surface doesn't otherwise cover.
+type KeyFunc func(r *http.Request) string
+
+// keySeparator joins a request's VaryBy key components into the final
+// rate-limit key. It's a control character so it can't collide with
+// anything a header, cookie, or path legitimately contains.
+const keySeparator = "\x00"
+
+// VaryBy configures which parts of a request contribute to the rate-limit
+// key. It replaces the older, mutually-exclusive choice between a single
+// header and the remote IP with a combination of any number of components,
+// e.g. `vary_by { header Authorization; path_prefix /api/; method }` gives
+// each user one counter per request method for all of /api/*.
+type VaryBy struct {
+	// RemoteIP varies by the client's IP address.
+	RemoteIP bool `json:"remote_ip,omitempty"`
+
+	// Headers varies by the value of one or more request headers.
+	Headers []string `json:"headers,omitempty"`
+
+	// Cookies varies by the value of one or more request cookies.
+	Cookies []string `json:"cookies,omitempty"`
+
+	// Method varies by request method, e.g. so POSTs are limited
+	// independently of GETs.
+	Method bool `json:"method,omitempty"`
+
+	// Path varies by (some function of) the request's URL path.
+	Path *VaryByPath `json:"path,omitempty"`
+
+	// Placeholders varies by the expansion of one or more Caddy
+	// placeholders, e.g. "{http.auth.user.id}".
+	Placeholders []string `json:"placeholders,omitempty"`
+
+	keyFuncs []KeyFunc
+	clientIP KeyFunc
+}
+
+// VaryByPath configures how the request path contributes to the key. With
+// neither Prefix nor Regexp set, the full path is used, which is rarely
+// what you want since it creates one counter per distinct path.
+type VaryByPath struct {
+	// Prefix, when the path starts with it, is used as the key component
+	// instead of the full path.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Regexp, if set, is matched against the path and its first match (or
+	// first capture group, if the pattern has one) is used as the key
+	// component.
+	Regexp string `json:"regexp,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// AddKeyFunc appends a custom KeyFunc to vb's configured components.
+func (vb *VaryBy) AddKeyFunc(fn KeyFunc) {
+	vb.keyFuncs = append(vb.keyFuncs, fn)
+}
+
+// provision builds vb's KeyFuncs from its configuration. clientIP is used
+// both for the RemoteIP component and as key's fallback, and should
+// normally be the owning Zone's ClientIPConfig.clientIP. provision must be
+// called once, before key is used.
+func (vb *VaryBy) provision(clientIP KeyFunc) error {
+	vb.clientIP = clientIP
+
+	var keyFuncs []KeyFunc
+
+	if vb.RemoteIP {
+		keyFuncs = append(keyFuncs, clientIP)
+	}
+
+	for _, header := range vb.Headers {
+		header := header
+		keyFuncs = append(keyFuncs, func(r *http.Request) string {
+			return header + "=" + r.Header.Get(header)
+		})
+	}
+
+	for _, cookie := range vb.Cookies {
+		cookie := cookie
+		keyFuncs = append(keyFuncs, func(r *http.Request) string {
+			c, err := r.Cookie(cookie)
+			if err != nil {
+				return cookie + "="
+			}
+			return cookie + "=" + c.Value
+		})
+	}
+
+	if vb.Method {
+		keyFuncs = append(keyFuncs, func(r *http.Request) string {
+			return r.Method
+		})
+	}
+
+	if vb.Path != nil {
+		if vb.Path.Regexp != "" {
+			re, err := regexp.Compile(vb.Path.Regexp)
+			if err != nil {
+				return fmt.Errorf("path regexp %q: %v", vb.Path.Regexp, err)
+			}
+			vb.Path.re = re
+		}
+		path := vb.Path
+		keyFuncs = append(keyFuncs, func(r *http.Request) string {
+			return path.component(r.URL.Path)
+		})
+	}
+
+	for _, placeholder := range vb.Placeholders {
+		placeholder := placeholder
+		keyFuncs = append(keyFuncs, func(r *http.Request) string {
+			repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+			if !ok {
+				return ""
+			}
+			return repl.ReplaceAll(placeholder, "")
+		})
+	}
+
+	// keyFuncs added via AddKeyFunc come last, after the configured ones.
+	vb.keyFuncs = append(keyFuncs, vb.keyFuncs...)
+
+	return nil
+}
+
+// component returns p's contribution to the rate-limit key for path.
+func (p *VaryByPath) component(path string) string {
+	switch {
+	case p.re != nil:
+		switch match := p.re.FindStringSubmatch(path); len(match) {
+		case 0:
+			return ""
+		case 1:
+			return match[0]
+		default:
+			return match[1]
+		}
+	case p.Prefix != "":
+		if strings.HasPrefix(path, p.Prefix) {
+			return p.Prefix
+		}
+		return path
+	default:
+		return path
+	}
+}
+
+// key computes the rate-limit key for r from vb's configured components. If
+// vb has none at all, it falls back to the client IP, so that an empty
+// `vary_by {}` block still behaves sensibly.
+func (vb *VaryBy) key(r *http.Request) string {
+	if len(vb.keyFuncs) == 0 {
+		return vb.clientIP(r)
+	}
+
+	parts := make([]string, len(vb.keyFuncs))
+	for i, fn := range vb.keyFuncs {
+		parts[i] = fn(r)
+	}
+
+	return strings.Join(parts, keySeparator)
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_VaryBy_key(t *testing.T) {
+	t.Run("defaults to the client IP when nothing is configured", func(t *testing.T) {
+		var vb VaryBy
+		if err := vb.provision(new(ClientIPConfig).clientIP); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.127:54321"
+
+		if key := vb.key(r); key != "10.0.0.127" {
+			t.Errorf("expected the client IP, got %q", key)
+		}
+	})
+
+	t.Run("combines configured components", func(t *testing.T) {
+		vb := VaryBy{
+			Headers: []string{"Authorization"},
+			Method:  true,
+			Path:    &VaryByPath{Prefix: "/api/"},
+		}
+		if err := vb.provision(new(ClientIPConfig).clientIP); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("POST", "/api/widgets/42", nil)
+		r.Header.Set("Authorization", "Bearer abc")
+
+		key := vb.key(r)
+		other := httptest.NewRequest("POST", "/api/widgets/99", nil)
+		other.Header.Set("Authorization", "Bearer abc")
+
+		if key != vb.key(other) {
+			t.Errorf("requests sharing a path prefix should share a key, got %q and %q", key, vb.key(other))
+		}
+
+		differentUser := httptest.NewRequest("POST", "/api/widgets/42", nil)
+		differentUser.Header.Set("Authorization", "Bearer xyz")
+
+		if key == vb.key(differentUser) {
+			t.Errorf("requests with a different Authorization header should not share a key")
+		}
+	})
+
+	t.Run("path regexp uses the first capture group", func(t *testing.T) {
+		vb := VaryBy{Path: &VaryByPath{Regexp: `^/users/(\w+)`}}
+		if err := vb.provision(new(ClientIPConfig).clientIP); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		r := httptest.NewRequest("GET", "/users/alice/settings", nil)
+		if key := vb.key(r); key != "alice" {
+			t.Errorf("expected capture group %q, got %q", "alice", key)
+		}
+	})
+}
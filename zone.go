@@ -0,0 +1,306 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// Zone is a named, shared rate-limiting policy: a window/algorithm/store/
+// vary-by configuration, plus optional allow/deny lists, referenced by one
+// or more `rate_limit` handlers via its name in the top-level ratelimit
+// app. Sharing a Zone across handlers - and, with a shared Store, across
+// Caddy instances - is what lets several routes all draw down the same
+// per-key budget instead of each handler keeping its own.
+type Zone struct {
+	// VaryBy configures which parts of a request make up the rate-limit
+	// key; defaults to the client IP if left unset.
+	VaryBy VaryBy `json:"vary_by,omitempty"`
+
+	// ClientIP configures how the client IP - used by VaryBy's RemoteIP
+	// component and to match requests against Allow/Deny - is determined.
+	// By default it's just the connection's remote address; configure
+	// TrustedProxies if Caddy sits behind a load balancer or CDN.
+	ClientIP ClientIPConfig `json:"client_ip,omitempty"`
+
+	// WindowLength is the window length for request rate checking (>= 5
+	// minutes).
+	WindowLength caddy.Duration `json:"window_length,omitempty"`
+
+	// MaxRequests is the max requests that should be processed per key in
+	// a given WindowLength.
+	MaxRequests int64 `json:"max_requests,omitempty"`
+
+	// Store configures where request counters are kept; defaults to an
+	// in-process map. Set to "redis" to share counters across instances.
+	Store StoreConfig `json:"store,omitempty"`
+
+	// Algorithm selects the rate limiting algorithm: "window" (the
+	// default, an interpolated sliding window counter) or "gcra"
+	// (generic cell rate algorithm, for smoother per-request pacing).
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Response configures what's written when a request is denied.
+	Response RateLimitResponse `json:"response,omitempty"`
+
+	// Allow lists client IPs and CIDR ranges that bypass this zone's rate
+	// limit entirely.
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny lists client IPs and CIDR ranges that are always blocked by
+	// this zone, without consuming any of its rate limit.
+	Deny []string `json:"deny,omitempty"`
+
+	// DryRun, if true, never actually blocks a request: the zone still
+	// tracks request counts and sets RateLimit-* headers as usual, and a
+	// denied IP is still matched against Deny, but a request that would
+	// have been blocked - by the rate limit or the deny list - is let
+	// through anyway. Useful for rolling a new zone's limits out against
+	// production traffic before enforcing them.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	store     Store
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+
+	// current window's request count per key; unused when Algorithm is "gcra"
+	currentWindow *RequestCountTracker
+
+	// previous window's request count per key; unused when Algorithm is "gcra"
+	previousWindow *RequestCountTracker
+
+	// gcra is built during provision when Algorithm is "gcra"
+	gcra *gcraLimiter
+}
+
+// provision sets up z's ACLs, counter store and algorithm, and, for the
+// window algorithm, starts the automatic window refresh process.
+func (z *Zone) provision(ctx caddy.Context) error {
+	if err := z.ClientIP.provision(); err != nil {
+		return err
+	}
+
+	if err := z.VaryBy.provision(z.ClientIP.clientIP); err != nil {
+		return err
+	}
+
+	if err := z.Response.provision(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	if z.allowNets, err = parseCIDRs(z.Allow); err != nil {
+		return fmt.Errorf("allow: %v", err)
+	}
+	if z.denyNets, err = parseCIDRs(z.Deny); err != nil {
+		return fmt.Errorf("deny: %v", err)
+	}
+
+	store, err := z.Store.build()
+	if err != nil {
+		return err
+	}
+	z.store = store
+
+	windowLength := time.Duration(z.WindowLength)
+
+	switch z.Algorithm {
+	case "", "window":
+		z.currentWindow = newRequestCountTracker(windowLength, z.store)
+		z.previousWindow = newPreviousRequestCountTracker(windowLength, z.store)
+
+		go func() { // automatic shuffling of request count tracking windows
+			for {
+				time.Sleep(time.Until(z.currentWindow.endTime))
+				z.refreshWindows()
+			}
+		}()
+	case "gcra":
+		z.gcra = newGCRALimiter(z.store, windowLength, z.MaxRequests)
+	default:
+		return fmt.Errorf("unrecognized algorithm %q", z.Algorithm)
+	}
+
+	return nil
+}
+
+// validate checks that z has a usable config.
+func (z *Zone) validate() error {
+	if z.MaxRequests <= 0 || z.WindowLength <= 0 {
+		return fmt.Errorf("max_requests and window_length must be positive")
+	}
+	switch z.Algorithm {
+	case "", "window", "gcra":
+	default:
+		return fmt.Errorf("unrecognized algorithm %q", z.Algorithm)
+	}
+	return nil
+}
+
+// parseCIDRs parses a list of CIDR ranges, as well as bare IPs (treated as
+// a single-address /32 or /128), into IPNets.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil {
+				if ip.To4() != nil {
+					cidr += "/32"
+				} else {
+					cidr += "/128"
+				}
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR range %q: %v", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func matchesAny(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshWindows() checks if currentWindow has reached its expiry time, and if it has,
+// moves currentWindow to previousWindow, and re-initialises currentWindow
+func (z *Zone) refreshWindows() (didRefresh bool) {
+	if z.currentWindow.endTime.Before(time.Now()) {
+		z.previousWindow = z.currentWindow
+		z.currentWindow = newRequestCountTracker(time.Duration(z.WindowLength), z.store)
+
+		didRefresh = true
+	}
+
+	return
+}
+
+// requestShouldBlock checks whether the request from a given key should block,
+// and increments the request counter for the key first
+// will block if current request would push the key over the blocking threshold
+func (z *Zone) requestShouldBlock(key string) (shouldBlock bool) {
+	z.currentWindow.addRequestFor(key)                         // increment request counter for key
+	return z.getInterpolatedRequestCount(key) > z.MaxRequests // check if they now are above the request limit
+}
+
+// getInterpolatedRequestCount gets an interpolated request count for a specified key
+// Always considers requests across the given windowDuration
+// More details: https://blog.cloudflare.com/counting-things-a-lot-of-different-things/
+//
+// For example say given a case where:
+// 	windowDuration is 20 minutes
+// 	current window started 10 minutes ago
+// 	requestCount would be 0.5 * currentWindowRequests + 0.5 * previousWindowRequests
+func (z Zone) getInterpolatedRequestCount(key string) (requestCount int64) {
+	now := time.Now()
+	windowDuration := time.Duration(z.WindowLength)
+
+	// calculate fraction of request that went in the current and previous windows
+	currentWindowFraction := now.Sub(z.currentWindow.startTime).Seconds() / windowDuration.Seconds()
+	previousWindowFraction := 1 - currentWindowFraction // thankfully this one's a bit easier to calculate!
+
+	requestCount += int64(math.Round(
+		float64(z.currentWindow.getRequestCountFor(key)) *
+			currentWindowFraction))
+	requestCount += int64(math.Round(
+		float64(z.previousWindow.getRequestCountFor(key)) *
+			previousWindowFraction))
+
+	return
+}
+
+// windowStatus returns the remaining request count and reset time for
+// key's current window, for use in RateLimit-Remaining/-Reset. It doesn't
+// itself count a request; call after requestShouldBlock.
+func (z Zone) windowStatus(key string) (remaining int64, resetAt time.Time) {
+	remaining = z.MaxRequests - z.getInterpolatedRequestCount(key)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, z.currentWindow.endTime
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers (per the IETF draft) on every response that z
+// rate-limits, whether or not it ends up being blocked. Requests matched
+// by Allow or Deny don't get them.
+func (z *Zone) setRateLimitHeaders(w http.ResponseWriter, remaining int64, resetAt time.Time) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.FormatInt(z.MaxRequests, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(time.Until(resetAt).Seconds())), 10))
+}
+
+// setRetryAfterHeader sets the Retry-After header, in seconds, on a
+// response that's about to be blocked.
+func setRetryAfterHeader(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+}
+
+// ServeHTTP applies z's allow/deny lists and rate limit to the request,
+// then either serves next or writes z's blocked response.
+func (z *Zone) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	ip := net.ParseIP(z.ClientIP.clientIP(r))
+
+	if matchesAny(ip, z.denyNets) {
+		if z.DryRun {
+			return next.ServeHTTP(w, r)
+		}
+		return z.writeBlockedResponse(w, r)
+	}
+	if matchesAny(ip, z.allowNets) {
+		return next.ServeHTTP(w, r)
+	}
+
+	key := z.VaryBy.key(r)
+
+	var (
+		shouldBlock bool
+		remaining   int64
+		resetAt     time.Time
+		retryAfter  time.Duration
+	)
+
+	if z.gcra != nil {
+		result := z.gcra.allow(key)
+		shouldBlock = !result.allowed
+		remaining = result.remaining
+		resetAt = result.resetAt
+		retryAfter = result.retryAfter
+	} else {
+		shouldBlock = z.requestShouldBlock(key)
+		remaining, resetAt = z.windowStatus(key)
+		if shouldBlock {
+			retryAfter = time.Until(z.currentWindow.endTime)
+		}
+	}
+
+	z.setRateLimitHeaders(w, remaining, resetAt)
+
+	if shouldBlock {
+		setRetryAfterHeader(w, retryAfter)
+		if z.DryRun {
+			return next.ServeHTTP(w, r)
+		}
+		return z.writeBlockedResponse(w, r)
+	}
+
+	return next.ServeHTTP(w, r)
+}
@@ -0,0 +1,233 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func Test_parseCIDRs(t *testing.T) {
+	t.Run("bare IPs become single-address CIDRs", func(t *testing.T) {
+		nets, err := parseCIDRs([]string{"10.0.0.127", "2001:db8::1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !matchesAny(net.ParseIP("10.0.0.127"), nets) {
+			t.Errorf("expected 10.0.0.127 to match its own /32")
+		}
+		if !matchesAny(net.ParseIP("2001:db8::1"), nets) {
+			t.Errorf("expected 2001:db8::1 to match its own /128")
+		}
+	})
+
+	t.Run("CIDR ranges are matched normally", func(t *testing.T) {
+		nets, err := parseCIDRs([]string{"10.0.0.0/8"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !matchesAny(net.ParseIP("10.1.2.3"), nets) {
+			t.Errorf("expected 10.1.2.3 to match 10.0.0.0/8")
+		}
+		if matchesAny(net.ParseIP("192.168.1.1"), nets) {
+			t.Errorf("expected 192.168.1.1 not to match 10.0.0.0/8")
+		}
+	})
+
+	t.Run("invalid entries error", func(t *testing.T) {
+		if _, err := parseCIDRs([]string{"not-an-ip"}); err == nil {
+			t.Errorf("expected an error for an invalid CIDR")
+		}
+	})
+}
+
+// provisionedZone provisions zone and fails the test if that errors.
+func provisionedZone(t *testing.T, zone *Zone) *Zone {
+	t.Helper()
+	if err := zone.provision(caddy.Context{}); err != nil {
+		t.Fatalf("unexpected error provisioning zone: %v", err)
+	}
+	return zone
+}
+
+// nextHandler returns a caddyhttp.Handler for use as ServeHTTP's next
+// argument, along with a pointer reporting whether it was invoked.
+func nextHandler() (caddyhttp.Handler, *bool) {
+	called := false
+	return caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		called = true
+		return nil
+	}), &called
+}
+
+// fastForwardWindow rewinds zone's current window deep into its own
+// length, leaving only a one-minute buffer before it rotates. Right after
+// provision, getInterpolatedRequestCount weighs the current window at
+// ~0 (startTime is ~now), which would make every request look free; this
+// mirrors the rewind Test_rateLimitOptions_blockingAndRequestCounting
+// does so a freshly provisioned zone behaves like one that's been running
+// for a while.
+func fastForwardWindow(zone *Zone) {
+	shift := time.Duration(zone.WindowLength) - time.Minute
+	zone.currentWindow.startTime = zone.currentWindow.startTime.Add(-shift)
+	zone.currentWindow.endTime = zone.currentWindow.endTime.Add(-shift)
+}
+
+func Test_Zone_ServeHTTP(t *testing.T) {
+	t.Run("denies a request from a denied IP without touching the limit", func(t *testing.T) {
+		zone := provisionedZone(t, &Zone{
+			WindowLength: caddy.Duration(time.Minute),
+			MaxRequests:  1,
+			Deny:         []string{"10.0.0.0/8"},
+		})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		w := httptest.NewRecorder()
+		next, called := nextHandler()
+
+		if err := zone.ServeHTTP(w, r, next); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *called {
+			t.Errorf("next should not have been called for a denied IP")
+		}
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected 429, got %d", w.Code)
+		}
+	})
+
+	t.Run("dry_run lets a denied IP through too", func(t *testing.T) {
+		zone := provisionedZone(t, &Zone{
+			WindowLength: caddy.Duration(time.Minute),
+			MaxRequests:  1,
+			Deny:         []string{"10.0.0.0/8"},
+			DryRun:       true,
+		})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		w := httptest.NewRecorder()
+		next, called := nextHandler()
+
+		if err := zone.ServeHTTP(w, r, next); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !*called {
+			t.Errorf("dry_run should let a denied IP through")
+		}
+	})
+
+	t.Run("bypasses the limit entirely for an allowed IP", func(t *testing.T) {
+		zone := provisionedZone(t, &Zone{
+			WindowLength: caddy.Duration(time.Minute),
+			MaxRequests:  0, // would block every request if not bypassed
+			Allow:        []string{"10.0.0.0/8"},
+		})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		w := httptest.NewRecorder()
+		next, called := nextHandler()
+
+		if err := zone.ServeHTTP(w, r, next); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !*called {
+			t.Errorf("next should have been called for an allowed IP")
+		}
+		if w.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("an allowed request should skip rate-limit headers entirely, got %q", w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("sets RateLimit-* headers and allows requests within the limit", func(t *testing.T) {
+		zone := provisionedZone(t, &Zone{
+			WindowLength: caddy.Duration(20 * time.Minute),
+			MaxRequests:  5,
+		})
+		fastForwardWindow(zone)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		w := httptest.NewRecorder()
+		next, called := nextHandler()
+
+		if err := zone.ServeHTTP(w, r, next); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !*called {
+			t.Errorf("next should have been called")
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "5" {
+			t.Errorf("expected RateLimit-Limit 5, got %q", got)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != "4" {
+			t.Errorf("expected RateLimit-Remaining 4, got %q", got)
+		}
+	})
+
+	t.Run("blocks with Retry-After once the limit is exceeded", func(t *testing.T) {
+		zone := provisionedZone(t, &Zone{
+			WindowLength: caddy.Duration(20 * time.Minute),
+			MaxRequests:  1,
+		})
+		fastForwardWindow(zone)
+
+		r1 := httptest.NewRequest("GET", "/", nil)
+		r1.RemoteAddr = "10.1.2.3:1234"
+		next1, _ := nextHandler()
+		zone.ServeHTTP(httptest.NewRecorder(), r1, next1)
+
+		r2 := httptest.NewRequest("GET", "/", nil)
+		r2.RemoteAddr = "10.1.2.3:1234"
+		w2 := httptest.NewRecorder()
+		next2, called2 := nextHandler()
+
+		if err := zone.ServeHTTP(w2, r2, next2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *called2 {
+			t.Errorf("next should not have been called once over the limit")
+		}
+		if w2.Code != http.StatusTooManyRequests {
+			t.Errorf("expected 429, got %d", w2.Code)
+		}
+		if w2.Header().Get("Retry-After") == "" {
+			t.Errorf("expected a Retry-After header")
+		}
+	})
+
+	t.Run("dry_run lets blocked requests through while still reporting them", func(t *testing.T) {
+		zone := provisionedZone(t, &Zone{
+			WindowLength: caddy.Duration(20 * time.Minute),
+			MaxRequests:  1,
+			DryRun:       true,
+		})
+		fastForwardWindow(zone)
+
+		r1 := httptest.NewRequest("GET", "/", nil)
+		r1.RemoteAddr = "10.1.2.3:1234"
+		next1, _ := nextHandler()
+		zone.ServeHTTP(httptest.NewRecorder(), r1, next1)
+
+		r2 := httptest.NewRequest("GET", "/", nil)
+		r2.RemoteAddr = "10.1.2.3:1234"
+		w2 := httptest.NewRecorder()
+		next2, called2 := nextHandler()
+
+		if err := zone.ServeHTTP(w2, r2, next2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !*called2 {
+			t.Errorf("dry_run should still call next even when over the limit")
+		}
+		if w2.Header().Get("Retry-After") == "" {
+			t.Errorf("expected a Retry-After header even in dry_run")
+		}
+	})
+}